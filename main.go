@@ -97,30 +97,72 @@ func main() {
 
 	// set up the database
 	dbConfYAML := k.Cut("db")
-	if engine := dbConfYAML.String("engine"); engine != "pebble" {
-		logger.Fatal().Msgf("unsupported database engine: %s", engine)
+	myDB, err := db.Open(dbConfYAML.String("engine"), dbConfYAML)
+	if err != nil {
+		logger.Fatal().Msgf("failed to set up database: %s", err)
 	}
-	myDB := db.NewPebbleDB(dbConfYAML.String("uri"))
 	if err := myDB.Open(); err != nil {
 		logger.Fatal().Msgf("failed to open database: %s", err)
 	}
+	myDB = db.Instrument(myDB)
 	defer myDB.Close()
 
 	// set up the API
 	apiConfYAML := k.Cut("api")
+
+	var authUsersAPI, authUsersAdmin map[string]map[string]string
+	if err := apiConfYAML.Unmarshal("auth_users_api", &authUsersAPI); err != nil {
+		logger.Fatal().Msgf("failed to parse auth_users_api: %s", err)
+	}
+	if err := apiConfYAML.Unmarshal("auth_users_admin", &authUsersAdmin); err != nil {
+		logger.Fatal().Msgf("failed to parse auth_users_admin: %s", err)
+	}
+	var namespaceRPS map[string]float64
+	if err := apiConfYAML.Unmarshal("namespace_rps", &namespaceRPS); err != nil {
+		logger.Fatal().Msgf("failed to parse namespace_rps: %s", err)
+	}
+	var adminRequiredGroup, apiRequiredScope map[string]string
+	if err := apiConfYAML.Unmarshal("admin_required_group", &adminRequiredGroup); err != nil {
+		logger.Fatal().Msgf("failed to parse admin_required_group: %s", err)
+	}
+	if err := apiConfYAML.Unmarshal("api_required_scope", &apiRequiredScope); err != nil {
+		logger.Fatal().Msgf("failed to parse api_required_scope: %s", err)
+	}
+
 	apiConf := api.Config{
-		ListenAddr:      apiConfYAML.String("listen"),
-		BasePath:        apiConfYAML.String("base_path_api"),
-		BasePathAdmin:   apiConfYAML.String("base_path_admin"),
-		IsTLS:           apiConfYAML.Bool("tls_enabled"),
-		TLSCert:         apiConfYAML.String("tls_cert"),
-		TLSKey:          apiConfYAML.String("tls_key"),
-		AuthMethodAPI:   apiConfYAML.String("auth_method_api"),
-		AuthUsersAPI:    apiConfYAML.StringMap("auth_users_api"),
-		AuthMethodAdmin: apiConfYAML.String("auth_method_admin"),
-		AuthUsersAdmin:  apiConfYAML.StringMap("auth_users_admin"),
-		Logger:          &logger,
-		RPS:             apiConfYAML.Float64("rps"),
+		ListenAddr:               apiConfYAML.String("listen"),
+		BasePath:                 apiConfYAML.String("base_path_api"),
+		BasePathAdmin:            apiConfYAML.String("base_path_admin"),
+		IsTLS:                    apiConfYAML.Bool("tls_enabled"),
+		TLSCert:                  apiConfYAML.String("tls_cert"),
+		TLSKey:                   apiConfYAML.String("tls_key"),
+		AutoTLSEnabled:           apiConfYAML.Bool("auto_tls.enabled"),
+		AutoTLSDomains:           apiConfYAML.Strings("auto_tls.domains"),
+		AutoTLSEmail:             apiConfYAML.String("auto_tls.email"),
+		AutoTLSCacheDir:          apiConfYAML.String("auto_tls.cache_dir"),
+		AutoTLSCADirURL:          apiConfYAML.String("auto_tls.ca_dir_url"),
+		AutoTLSChallenge:         apiConfYAML.String("auto_tls.challenge"),
+		AutoTLSHTTPChallengeAddr: apiConfYAML.String("auto_tls.http_challenge_addr"),
+		AutoTLSDNSProvider:       apiConfYAML.String("auto_tls.dns_provider"),
+		AutoTLSDNSCredentials:    apiConfYAML.StringMap("auto_tls.dns_credentials"),
+		AuthMethodAPI:            apiConfYAML.String("auth_method_api"),
+		AuthUsersAPI:             authUsersAPI,
+		AuthMethodAdmin:          apiConfYAML.String("auth_method_admin"),
+		AuthUsersAdmin:           authUsersAdmin,
+		OIDCIssuer:               apiConfYAML.String("oidc_issuer"),
+		OIDCClientID:             apiConfYAML.String("oidc_client_id"),
+		OIDCRequiredAudience:     apiConfYAML.String("oidc_required_audience"),
+		AdminRequiredGroup:       adminRequiredGroup,
+		APIRequiredScope:         apiRequiredScope,
+		SuperAdminUsers:          apiConfYAML.StringMap("super_admin_users"),
+		SuperAdminRequiredGroup:  apiConfYAML.String("super_admin_required_group"),
+		Logger:                   &logger,
+		RPS:                      apiConfYAML.Float64("rps"),
+		NamespaceRPS:             namespaceRPS,
+		MetricsEnabled:           apiConfYAML.Bool("metrics_enabled"),
+		MetricsListen:            apiConfYAML.String("metrics_listen"),
+		IngestBatchSize:          apiConfYAML.Int("ingest.batch_size"),
+		IngestDedupWindow:        apiConfYAML.Duration("ingest.dedup_window"),
 	}
 	myAPI := api.NewAPI(apiConf, myDB)
 	// Blocking call
@@ -2,22 +2,32 @@ package api
 
 import (
 	"crypto/subtle"
+	stdtls "crypto/tls"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/mosajjal/nodzilla/pkg/db"
+	"github.com/mosajjal/nodzilla/pkg/metrics"
+	"github.com/mosajjal/nodzilla/pkg/tls"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
-	"golang.org/x/time/rate"
 )
 
 // API is the main struct for the API. can be used for both Admin and Query APIs
 type API struct {
 	*echo.Echo
-	DB          db.NodDB
-	middlewares []echo.MiddlewareFunc
-	C           Config
+	DB           db.NodDB
+	middlewares  []echo.MiddlewareFunc
+	C            Config
+	tlsManager   *tls.Manager
+	tlsStop      chan struct{}
+	oidcVerifier *oidc.IDTokenVerifier
+	ingestDedup  *dedupSet
 }
 
 // Config struct is corresponding to the YAML payload in api section of the config file
@@ -34,18 +44,81 @@ type Config struct {
 	TLSCert string
 	// TLSKey is the path to the TLS key
 	TLSKey string
-	// AuthMethodAPI is the authentication method to use. Can be "none" or "basic"
+	// AutoTLSEnabled enables automatic certificate issuance/renewal via ACME,
+	// in place of IsTLS/TLSCert/TLSKey
+	AutoTLSEnabled bool
+	// AutoTLSDomains is the list of hostnames to obtain a certificate for
+	AutoTLSDomains []string
+	// AutoTLSEmail is the contact address registered with the ACME CA
+	AutoTLSEmail string
+	// AutoTLSCacheDir is where the ACME account key and issued certs are stored
+	AutoTLSCacheDir string
+	// AutoTLSCADirURL overrides the ACME directory URL (defaults to Let's Encrypt production)
+	AutoTLSCADirURL string
+	// AutoTLSChallenge selects the ACME challenge type: "http-01" or "dns-01"
+	AutoTLSChallenge string
+	// AutoTLSHTTPChallengeAddr is the listen address for the HTTP-01 challenge
+	// responder, e.g. ":80"
+	AutoTLSHTTPChallengeAddr string
+	// AutoTLSDNSProvider selects the DNS-01 provider: cloudflare, route53, gandi, sakuracloud
+	AutoTLSDNSProvider string
+	// AutoTLSDNSCredentials holds the provider-specific credentials, keyed by
+	// the env var name the provider expects (e.g. CLOUDFLARE_DNS_API_TOKEN)
+	AutoTLSDNSCredentials map[string]string
+	// AuthMethodAPI is the authentication method(s) to use for the API base
+	// path, as a comma-separated list. Can be "none", "basic", "oidc", or
+	// "basic,oidc" to accept either.
 	AuthMethodAPI string
-	// AuthUsersAPI is a map of username:password for basic auth
-	AuthUsersAPI map[string]string
-	// AuthMethodAdmin is the authentication method to use. Can be "none" or "basic"
+	// AuthUsersAPI is a map of namespace -> username:password for basic auth
+	AuthUsersAPI map[string]map[string]string
+	// AuthMethodAdmin is the authentication method(s) to use for the Admin
+	// base path. Same format as AuthMethodAPI.
 	AuthMethodAdmin string
-	// AuthUsersAdmin is a map of username:password for basic auth
-	AuthUsersAdmin map[string]string
+	// AuthUsersAdmin is a map of namespace -> username:password for basic auth
+	AuthUsersAdmin map[string]map[string]string
+	// OIDCIssuer is the OIDC issuer URL used to discover the provider's
+	// signing keys and endpoints
+	OIDCIssuer string
+	// OIDCClientID is the expected "aud"/"azp" claim of incoming ID tokens
+	OIDCClientID string
+	// OIDCRequiredAudience, if set, must also be present in the token's
+	// audience claim, in addition to OIDCClientID
+	OIDCRequiredAudience string
+	// AdminRequiredGroup is a map of namespace -> group. If a namespace's
+	// entry is set, an OIDC principal must belong to that group (via the
+	// "groups" claim) to access that namespace's Admin base path
+	AdminRequiredGroup map[string]string
+	// APIRequiredScope is a map of namespace -> scope. If a namespace's entry
+	// is set, an OIDC principal must hold that scope (via the
+	// space-delimited "scope" claim) to access that namespace's API base path
+	APIRequiredScope map[string]string
+	// SuperAdminUsers is a map of username:password for basic auth to the
+	// cross-tenant admin endpoints (e.g. listing every namespace and its
+	// size), independent of any single tenant's AuthUsersAdmin
+	SuperAdminUsers map[string]string
+	// SuperAdminRequiredGroup, if set, is the group an OIDC principal must
+	// belong to (via the "groups" claim) to access the cross-tenant admin
+	// endpoints
+	SuperAdminRequiredGroup string
 	// Logger is the logger to use
 	Logger *zerolog.Logger
-	// RPS is the rate limit for the API
+	// RPS is the default rate limit for the API, applied to any namespace not
+	// present in NamespaceRPS
 	RPS float64
+	// NamespaceRPS overrides RPS for specific namespaces
+	NamespaceRPS map[string]float64
+	// MetricsEnabled exposes a /metrics endpoint with Prometheus metrics
+	MetricsEnabled bool
+	// MetricsListen, if set, serves /metrics on a separate listen address
+	// instead of the main API bind
+	MetricsListen string
+	// IngestBatchSize is how many ingest_stream lines are buffered before
+	// being flushed to the database as a single batch
+	IngestBatchSize int
+	// IngestDedupWindow, if > 0, makes ingest_stream skip domains it has
+	// already written within this window, to cut write amplification from
+	// feeds that re-broadcast hot domains
+	IngestDedupWindow time.Duration
 }
 
 // NewAPI creates a new API instance. It won't start till ListenAndServe is called
@@ -58,18 +131,27 @@ func NewAPI(config Config, db db.NodDB) *API {
 		DB:   db,
 		C:    config,
 	}
+	if config.IngestDedupWindow > 0 {
+		api.ingestDedup = newDedupSet(config.IngestDedupWindow)
+	}
 	// Add the middlewares
 	api.addMiddlewares()
 	// Add the query paths
 	api.AddQueryPaths()
 	// Add the admin paths
 	api.AddAdminPaths()
+	// Add the metrics endpoint, if enabled
+	api.AddMetrics()
 	// Return the API instance
 	return api
 }
 
 // ListenAndServe starts the API server based on the config
 func (api *API) ListenAndServe() {
+	if api.C.AutoTLSEnabled {
+		api.Logger.Fatal(api.startAutoTLS())
+		return
+	}
 	if api.C.IsTLS {
 		api.Logger.Fatal(api.StartTLS(api.C.ListenAddr, api.C.TLSCert, api.C.TLSKey))
 	} else {
@@ -77,9 +159,35 @@ func (api *API) ListenAndServe() {
 	}
 }
 
+// startAutoTLS obtains (and keeps renewed) a certificate via ACME and serves
+// it through Echo's TLSServer, using tls.Manager as the GetCertificate source.
+func (api *API) startAutoTLS() error {
+	mgr, err := tls.NewManager(tls.Config{
+		Domains:           api.C.AutoTLSDomains,
+		Email:             api.C.AutoTLSEmail,
+		CacheDir:          api.C.AutoTLSCacheDir,
+		CADirURL:          api.C.AutoTLSCADirURL,
+		Challenge:         api.C.AutoTLSChallenge,
+		HTTPChallengeAddr: api.C.AutoTLSHTTPChallengeAddr,
+		DNSProvider:       api.C.AutoTLSDNSProvider,
+		DNSCredentials:    api.C.AutoTLSDNSCredentials,
+		Logger:            api.C.Logger,
+	})
+	if err != nil {
+		return err
+	}
+	api.tlsManager = mgr
+	api.tlsStop = make(chan struct{})
+	api.tlsManager.StartRenewalLoop(api.tlsStop)
+
+	s := api.TLSServer
+	s.Addr = api.C.ListenAddr
+	s.TLSConfig = &stdtls.Config{GetCertificate: api.tlsManager.GetCertificate}
+	return api.StartServer(s)
+}
+
 func (api *API) addMiddlewares() {
-	// the middlewares seems to be in order. so we'll log first, then rate limit, then auth
-	// set up a logger
+	// set up a logger, applied globally so every request (including /metrics) is logged
 	api.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogURI:    true,
 		LogStatus: true,
@@ -92,75 +200,190 @@ func (api *API) addMiddlewares() {
 		},
 	}))
 
-	// the following rate limit restricts the number of requests to 1 per second for an IP
-	// TODO: make this configurable and more flexible
-	api.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(rate.Limit(api.C.RPS))))
+	// instrument every request with nodzilla_http_requests_total/nodzilla_http_request_duration_seconds,
+	// applied globally so /metrics itself stays covered
+	api.Use(api.instrumentRequests)
+
+	// the rate limit and auth middlewares below are attached per-route (see
+	// api.middlewares, used by AddQueryPaths/AddAdminPaths), not globally, so
+	// that /metrics can be registered outside of them.
+
+	// each namespace gets its own rate limit bucket per IP, falling back to
+	// RPS when a namespace isn't present in NamespaceRPS
+	api.middlewares = append(api.middlewares, middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store:               newNamespaceRateLimiterStore(api.C.RPS, api.C.NamespaceRPS),
+		IdentifierExtractor: rateLimiterIdentifier,
+	}))
 
 	// add auth middleware if auth is enabled
 	if api.C.AuthMethodAPI != "none" || api.C.AuthMethodAdmin != "none" {
-		api.Use(middleware.BasicAuth(func(username, password string, c echo.Context) (bool, error) {
-			// depending on URL being on admin prefix or not, use the appropriate auth method
-			// check authentication schemme for admin base path
-			if strings.HasPrefix(c.Path(), api.C.BasePathAdmin) {
-				if api.C.AuthMethodAdmin == "none" {
-					return true, nil
-				}
-				for user, pass := range api.C.AuthUsersAdmin {
-					if subtle.ConstantTimeCompare([]byte(username), []byte(user)) == 1 &&
-						subtle.ConstantTimeCompare([]byte(password), []byte(pass)) == 1 {
-						return true, nil
-					}
-				}
-				return false, nil
+		if authMethodHas(api.C.AuthMethodAPI, "oidc") || authMethodHas(api.C.AuthMethodAdmin, "oidc") {
+			verifier, err := newOIDCVerifier(api.C.OIDCIssuer, api.C.OIDCClientID)
+			if err != nil {
+				api.C.Logger.Fatal().Msgf("failed to set up OIDC: %s", err)
 			}
-			// check authentication schemme for API base path
-			if strings.HasPrefix(c.Path(), api.C.BasePath) || c.Path() == "" {
-				if api.C.AuthMethodAPI == "none" {
-					return true, nil
-				}
-				for user, pass := range api.C.AuthUsersAPI {
-					if subtle.ConstantTimeCompare([]byte(username), []byte(user)) == 1 &&
-						subtle.ConstantTimeCompare([]byte(password), []byte(pass)) == 1 {
-						return true, nil
-					}
-				}
+			api.oidcVerifier = verifier
+		}
+		api.middlewares = append(api.middlewares, api.authMiddleware)
+	}
+}
+
+// authMiddleware enforces the configured AuthMethodAPI/AuthMethodAdmin for
+// the base path being accessed. Both "basic" and "oidc" may be active at
+// once: the bearer token is tried first, then basic auth. The cross-tenant
+// admin endpoints (e.g. /admin/namespaces) are gated by their own
+// SuperAdminUsers/SuperAdminRequiredGroup, never by a tenant namespace's
+// credentials, since they aren't scoped to any single namespace.
+func (api *API) authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		isAdmin := strings.Contains(c.Path(), api.C.BasePathAdmin)
+		isCrossTenant := c.Param("namespace") == "" && isAdmin
+		method := api.C.AuthMethodAPI
+		if isAdmin {
+			method = api.C.AuthMethodAdmin
+		}
+
+		// an empty method is not configured, not "none": fail closed and fall
+		// through to the credential checks below, which deny everything
+		if authMethodHas(method, "none") {
+			return next(c)
+		}
+		namespace := namespaceParam(c)
+		if authMethodHas(method, "oidc") && api.verifyBearer(c, isAdmin, isCrossTenant, namespace) {
+			return next(c)
+		}
+		if authMethodHas(method, "basic") {
+			username, password, ok := c.Request().BasicAuth()
+			if ok && api.checkBasicAuth(isAdmin, isCrossTenant, namespace, username, password) {
+				return next(c)
 			}
-			return false, nil
-		}))
+		}
+		return echo.ErrUnauthorized
+	}
+}
+
+// authMethodHas reports whether name is one of the comma-separated methods in method.
+func authMethodHas(method, name string) bool {
+	for _, m := range strings.Split(method, ",") {
+		if strings.TrimSpace(m) == name {
+			return true
+		}
 	}
+	return false
 }
 
-// AddQueryPaths adds the two query URLs to the Echo instance
+// checkBasicAuth validates username/password against the configured
+// credentials for namespace's API or Admin base path, or against
+// SuperAdminUsers for the cross-tenant admin endpoints.
+func (api *API) checkBasicAuth(isAdmin, isCrossTenant bool, namespace, username, password string) bool {
+	var users map[string]string
+	switch {
+	case isCrossTenant:
+		users = api.C.SuperAdminUsers
+	case isAdmin:
+		users = api.C.AuthUsersAdmin[namespace]
+	default:
+		users = api.C.AuthUsersAPI[namespace]
+	}
+	for user, pass := range users {
+		if subtle.ConstantTimeCompare([]byte(username), []byte(user)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(pass)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceParam returns the :namespace route parameter, falling back to
+// db.DefaultNamespace for routes that don't carry one (e.g. the cross-tenant
+// admin endpoints).
+func namespaceParam(c echo.Context) string {
+	if ns := c.Param("namespace"); ns != "" {
+		return ns
+	}
+	return db.DefaultNamespace
+}
+
+// instrumentRequests records nodzilla_http_requests_total and
+// nodzilla_http_request_duration_seconds for every request.
+func (api *API) instrumentRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		status := c.Response().Status
+		if err != nil {
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			}
+		}
+		path := c.Path()
+		method := c.Request().Method
+		metrics.HTTPRequestsTotal.WithLabelValues(path, method, strconv.Itoa(status)).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(path, method).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// AddMetrics registers the /metrics endpoint, outside the rate-limit/auth
+// middleware chain. If MetricsListen is set, it is served on a separate
+// listener instead of the main API bind.
+func (api *API) AddMetrics() {
+	if !api.C.MetricsEnabled {
+		return
+	}
+	if api.C.MetricsListen != "" {
+		go func() {
+			server := &http.Server{Addr: api.C.MetricsListen, Handler: promhttp.Handler()}
+			if err := server.ListenAndServe(); err != nil {
+				api.C.Logger.Error().Msgf("metrics server stopped: %s", err)
+			}
+		}()
+		return
+	}
+	api.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+}
+
+// AddQueryPaths adds the two query URLs to the Echo instance, one per tenant
+// namespace (/{namespace}/query/...).
 func (api *API) AddQueryPaths() {
 	// query path is meant to be used by a real user through a browser
 	// so it doesn't require a JSON payload
-	api.GET(api.C.BasePath+"query/:domain", api.query, api.middlewares...)
+	api.GET("/:namespace"+api.C.BasePath+"query/:domain", api.query, api.middlewares...)
 	// query_many path gets a list of domains as payload and returns a JSON object
 	// for each domain and it's respective registration date
-	// $ curl -XGET http://127.0.0.1:3000/query_many -H 'Content-Type: application/json' -d '["domain1.com","domain2.com"]'
+	// $ curl -XGET http://127.0.0.1:3000/default/query_many -H 'Content-Type: application/json' -d '["domain1.com","domain2.com"]'
 	// > {"domain1.com":"2020-01-01T12:00:00+12:00", "domain2.com": "2020-01-01T12:00:00+12:00"}
-	api.GET(api.C.BasePath+"query_many", api.queryMany, api.middlewares...)
+	api.GET("/:namespace"+api.C.BasePath+"query_many", api.queryMany, api.middlewares...)
 }
 
-// AddAdminPaths adds the admin URLs to the Echo instance
+// AddAdminPaths adds the admin URLs to the Echo instance, one per tenant
+// namespace (/{namespace}/admin/...), plus a cross-tenant endpoint for
+// listing namespaces.
 func (api *API) AddAdminPaths() {
 	// add_domain path is used to add a new domain to the database
-	api.POST(api.C.BasePathAdmin+"/add_domain", api.addDomain, api.middlewares...)
+	api.POST("/:namespace"+api.C.BasePathAdmin+"/add_domain", api.addDomain, api.middlewares...)
 	// add_domains path is used to add a list of domains to the database
-	api.POST(api.C.BasePathAdmin+"/add_domains", api.addDomains, api.middlewares...)
+	api.POST("/:namespace"+api.C.BasePathAdmin+"/add_domains", api.addDomains, api.middlewares...)
 	// delete_domain path is used to delete a domain from the database
-	api.DELETE(api.C.BasePathAdmin+"/delete_domain/:domain", api.deleteDomain, api.middlewares...)
+	api.DELETE("/:namespace"+api.C.BasePathAdmin+"/delete_domain/:domain", api.deleteDomain, api.middlewares...)
 	// delete_domains path is used to delete a list of domains from the database
-	api.DELETE(api.C.BasePathAdmin+"/delete_domains", api.deleteDomains, api.middlewares...)
+	api.DELETE("/:namespace"+api.C.BasePathAdmin+"/delete_domains", api.deleteDomains, api.middlewares...)
+	// ingest_stream is a higher-throughput alternative to add_domains for feeds
+	// with millions of entries/day; see ingestStream's doc comment
+	api.POST("/:namespace"+api.C.BasePathAdmin+"/ingest_stream", api.ingestStream, api.middlewares...)
+	// namespaces lists every known namespace and its size; it spans tenants,
+	// so it lives directly under BasePathAdmin rather than under a :namespace
+	api.GET(api.C.BasePathAdmin+"/namespaces", api.listNamespaces, api.middlewares...)
 }
 
 func (api *API) query(c echo.Context) error {
+	namespace := namespaceParam(c)
 	// Get the domain from the URL
 	domain := c.Param("domain")
 	// Query the database for the domain
 	// If the domain is not found, return a 404
 	// If the domain is found, return a JSON object with the domain and it's registration date
-	entry, err := api.DB.Query(domain)
+	entry, err := api.DB.Query(namespace, domain)
 	if err != nil {
 		return c.JSON(500, map[string]string{"error": "internal server error"})
 	}
@@ -171,6 +394,7 @@ func (api *API) query(c echo.Context) error {
 }
 
 func (api *API) queryMany(c echo.Context) error {
+	namespace := namespaceParam(c)
 	// Get the list of domains from the JSON payload
 	var domains []string
 	if err := c.Bind(&domains); err != nil {
@@ -179,7 +403,7 @@ func (api *API) queryMany(c echo.Context) error {
 	// Query the database for each domain
 	// If the domain is not found, return a 404
 	// If the domain is found, return a JSON object with the domain and it's registration date
-	entries, err := api.DB.QueryMany(domains)
+	entries, err := api.DB.QueryMany(namespace, domains)
 	if err != nil {
 		return c.JSON(500, map[string]string{"error": "internal server error"})
 	}
@@ -196,6 +420,8 @@ func (api *API) addDomain(c echo.Context) error {
 	if err := c.Bind(&entry); err != nil {
 		return c.JSON(400, map[string]string{"error": "bad request"})
 	}
+	// the namespace always comes from the URL, not the request body
+	entry.Namespace = namespaceParam(c)
 	// Add the domain to the database
 	// If the domain is not added successfully, return a 409
 	// If the domain is added successfully, return a 200
@@ -214,6 +440,11 @@ func (api *API) addDomains(c echo.Context) error {
 	if err := c.Bind(&entries); err != nil {
 		return c.JSON(400, map[string]string{"error": "bad request"})
 	}
+	// the namespace always comes from the URL, not the request body
+	namespace := namespaceParam(c)
+	for i := range entries {
+		entries[i].Namespace = namespace
+	}
 	// Add the domains to the database
 	// if the domain is not added successfully, return a 409
 	// If the domain is added successfully, return a 200
@@ -225,12 +456,13 @@ func (api *API) addDomains(c echo.Context) error {
 }
 
 func (api *API) deleteDomain(c echo.Context) error {
+	namespace := namespaceParam(c)
 	// Get the domain from the URL
 	domain := c.Param("domain")
 	// Delete the domain from the database
 	// If the domain is not found, return a 404
 	// If the domain is deleted successfully, return a 200
-	err := api.DB.Delete(domain)
+	err := api.DB.Delete(namespace, domain)
 	if err != nil {
 		return c.JSON(404, map[string]string{"error": "domain not found"})
 	}
@@ -238,6 +470,7 @@ func (api *API) deleteDomain(c echo.Context) error {
 }
 
 func (api *API) deleteDomains(c echo.Context) error {
+	namespace := namespaceParam(c)
 	// Get the list of domains from the JSON payload
 	var domains []string
 	if err := c.Bind(&domains); err != nil {
@@ -246,9 +479,19 @@ func (api *API) deleteDomains(c echo.Context) error {
 	// Delete the domains from the database
 	// If the domain is not found, return a 404
 	// If the domain is deleted successfully, return a 200
-	err := api.DB.DeleteMany(domains)
+	err := api.DB.DeleteMany(namespace, domains)
 	if err != nil {
 		return c.JSON(404, map[string]string{"error": "domain not found"})
 	}
 	return c.JSON(200, map[string]string{"status": "ok"})
 }
+
+// listNamespaces returns every known namespace along with its number of
+// entries. It spans all tenants, so it is not itself namespace-scoped.
+func (api *API) listNamespaces(c echo.Context) error {
+	namespaces, err := api.DB.Namespaces()
+	if err != nil {
+		return c.JSON(500, map[string]string{"error": "internal server error"})
+	}
+	return c.JSON(200, namespaces)
+}
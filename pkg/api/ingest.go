@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mosajjal/nodzilla/pkg/db"
+)
+
+// defaultIngestBatchSize is used when Config.IngestBatchSize isn't set.
+const defaultIngestBatchSize = 10000
+
+// dedupEvictInterval bounds how often dedupSet sweeps for expired entries,
+// so a long-running ingest doesn't scan the whole set on every single domain.
+const dedupEvictInterval = 1000
+
+// dedupSet is a time-bounded set of recently seen keys, used by ingestStream
+// to skip re-writing domains the feed has already sent within the configured
+// window. Entries are evicted lazily, so memory stays bounded by how many
+// distinct keys arrive per window rather than by total ingested volume.
+type dedupSet struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+	calls  int
+}
+
+func newDedupSet(window time.Duration) *dedupSet {
+	return &dedupSet{window: window, seen: make(map[string]time.Time)}
+}
+
+// Seen reports whether key was recorded within the window. It does not
+// itself record key; call Mark once the write covering it durably succeeds
+// (see ingestStream), so a failed batch commit doesn't poison the dedup
+// window against a client retrying those same lines.
+func (d *dedupSet) Seen(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.seen[key]
+	return ok && time.Since(t) < d.window
+}
+
+// Mark records key as seen as of now, evicting expired entries periodically.
+func (d *dedupSet) Mark(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.seen[key] = now
+
+	d.calls++
+	if d.calls%dedupEvictInterval == 0 {
+		for k, t := range d.seen {
+			if now.Sub(t) >= d.window {
+				delete(d.seen, k)
+			}
+		}
+	}
+}
+
+// ingestResult is one line of the ingest_stream NDJSON response.
+type ingestResult struct {
+	Line   int    `json:"line"`
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ingestStream consumes a newline-delimited JSON request body, one db.Entry
+// per line, and flushes it to the database in batches of
+// Config.IngestBatchSize via AddManyFast, followed by a final Flush for
+// durability. It streams back one NDJSON result line per commit boundary,
+// carrying the last line that commit covers (plus an immediate result for
+// each line that fails to parse or is skipped as a duplicate), so clients can resume a feed
+// from the last acknowledged line instead of resending the whole batch. This
+// is the high-throughput counterpart to addDomains, meant for feeds with
+// millions of entries/day that don't fit comfortably in memory as a single
+// JSON array.
+func (api *API) ingestStream(c echo.Context) error {
+	namespace := namespaceParam(c)
+
+	batchSize := api.C.IngestBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultIngestBatchSize
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	resp.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(resp)
+
+	emit := func(result ingestResult) {
+		enc.Encode(result)
+		resp.Flush()
+	}
+
+	batch := make([]db.Entry, 0, batchSize)
+	lines := make([]int, 0, batchSize)
+	dedupKeys := make([]string, 0, batchSize)
+	// inFlight catches repeats of the same domain within the batch currently
+	// being assembled, which dedupSet alone can't: its Seen/Mark split means a
+	// key isn't recorded as seen until its batch has already committed, so
+	// without this, a domain repeated several times in one request (the exact
+	// "feed re-broadcasts hot domains" case dedup_window exists for) would be
+	// written once per occurrence instead of deduped.
+	inFlight := make(map[string]struct{}, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := api.DB.AddManyFast(batch)
+		if err == nil && api.ingestDedup != nil {
+			// only mark these domains seen now that the write covering them
+			// has actually committed, so a failed batch can be retried
+			for _, key := range dedupKeys {
+				api.ingestDedup.Mark(key)
+			}
+		}
+		last := lines[len(lines)-1]
+		if err != nil {
+			emit(ingestResult{Line: last, Error: err.Error()})
+		} else {
+			emit(ingestResult{Line: last, Status: "ok"})
+		}
+		batch = batch[:0]
+		lines = lines[:0]
+		dedupKeys = dedupKeys[:0]
+		for key := range inFlight {
+			delete(inFlight, key)
+		}
+	}
+
+	scanner := bufio.NewScanner(c.Request().Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var entry db.Entry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			emit(ingestResult{Line: line, Error: err.Error()})
+			continue
+		}
+		entry.Namespace = namespace
+
+		dedupKey := namespace + "\x00" + entry.Domain
+		if api.ingestDedup != nil {
+			_, queuedAlready := inFlight[dedupKey]
+			if queuedAlready || api.ingestDedup.Seen(dedupKey) {
+				emit(ingestResult{Line: line, Status: "skipped"})
+				continue
+			}
+			inFlight[dedupKey] = struct{}{}
+		}
+
+		batch = append(batch, entry)
+		lines = append(lines, line)
+		dedupKeys = append(dedupKeys, dedupKey)
+		if len(batch) >= batchSize {
+			flush()
+		}
+	}
+	flush()
+
+	if err := api.DB.Flush(); err != nil {
+		api.C.Logger.Error().Msgf("ingest_stream: final flush failed: %s", err)
+	}
+	if err := scanner.Err(); err != nil {
+		emit(ingestResult{Line: line, Error: err.Error()})
+	}
+	return nil
+}
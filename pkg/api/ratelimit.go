@@ -0,0 +1,60 @@
+package api
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+)
+
+// namespaceRateLimiterStore enforces a separate RPS budget per tenant
+// namespace, so one tenant's traffic can't exhaust another's quota. Each
+// namespace gets its own middleware.RateLimiterMemoryStore, created lazily on
+// first use and keyed again internally by client IP.
+type namespaceRateLimiterStore struct {
+	mu           sync.Mutex
+	stores       map[string]middleware.RateLimiterStore
+	defaultRPS   float64
+	namespaceRPS map[string]float64
+}
+
+// newNamespaceRateLimiterStore returns a store that rate-limits namespaces
+// present in namespaceRPS at their configured RPS, and every other namespace
+// at defaultRPS.
+func newNamespaceRateLimiterStore(defaultRPS float64, namespaceRPS map[string]float64) *namespaceRateLimiterStore {
+	return &namespaceRateLimiterStore{
+		stores:       make(map[string]middleware.RateLimiterStore),
+		defaultRPS:   defaultRPS,
+		namespaceRPS: namespaceRPS,
+	}
+}
+
+// Allow implements middleware.RateLimiterStore. identifier is
+// "namespace:client_ip", as produced by rateLimiterIdentifier.
+func (s *namespaceRateLimiterStore) Allow(identifier string) (bool, error) {
+	namespace, _, _ := strings.Cut(identifier, ":")
+	return s.storeFor(namespace).Allow(identifier)
+}
+
+func (s *namespaceRateLimiterStore) storeFor(namespace string) middleware.RateLimiterStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if store, ok := s.stores[namespace]; ok {
+		return store
+	}
+	rps := s.defaultRPS
+	if configured, ok := s.namespaceRPS[namespace]; ok {
+		rps = configured
+	}
+	store := middleware.NewRateLimiterMemoryStore(rate.Limit(rps))
+	s.stores[namespace] = store
+	return store
+}
+
+// rateLimiterIdentifier extracts "namespace:client_ip" from the request, so
+// namespaceRateLimiterStore can bucket each tenant's visitors separately.
+func rateLimiterIdentifier(c echo.Context) (string, error) {
+	return namespaceParam(c) + ":" + c.RealIP(), nil
+}
@@ -2,17 +2,31 @@
 // from any database.
 package db
 
-import "time"
+import (
+	"fmt"
+	"time"
+
+	"github.com/knadh/koanf"
+)
+
+// DefaultNamespace is the tenant new entries and lookups fall into when no
+// namespace is specified, and the namespace pre-existing bare-domain keys
+// are treated as belonging to.
+const DefaultNamespace = "default"
 
 // Entry represents a single domain entry in the database
 type Entry struct {
 	// Domain name
 	Domain string `json:"domain"`
+	// Namespace is the tenant this entry belongs to
+	Namespace string `json:"namespace"`
 	// Date of first observation
 	RegistrationDate time.Time `json:"registration_date"`
 }
 
-// NodDB is the interface that must be implemented by any database that is to be used
+// NodDB is the interface that must be implemented by any database that is to be used.
+// Reads and deletes are namespace-scoped so multiple tenants can share a backend
+// without seeing each other's domains; Add/AddMany take the namespace from Entry.Namespace.
 type NodDB interface {
 	// Open opens a connection to the database
 	Open() error
@@ -22,12 +36,44 @@ type NodDB interface {
 	Add(Entry) error
 	// AddMany adds multiple new domains to the database
 	AddMany([]Entry) error
-	// Delete removes a domain from the database
-	Delete(string) error
-	// DeleteMany removes multiple domains from the database
-	DeleteMany([]string) error
-	// Query returns the date of first observation for a given domain
-	Query(string) (Entry, error)
-	// QueryMany returns the date of first observation for multiple domains
-	QueryMany([]string) ([]Entry, error)
+	// AddManyFast is like AddMany but may relax durability guarantees for
+	// throughput; callers that need a durability guarantee should call Flush
+	// afterward. Intended for high-volume streaming ingestion.
+	AddManyFast([]Entry) error
+	// Flush persists any writes made via AddManyFast. Backends that don't
+	// relax durability in AddManyFast can implement this as a no-op.
+	Flush() error
+	// Delete removes a domain from the given namespace
+	Delete(namespace, domain string) error
+	// DeleteMany removes multiple domains from the given namespace
+	DeleteMany(namespace string, domains []string) error
+	// Query returns the date of first observation for a given domain in the given namespace
+	Query(namespace, domain string) (Entry, error)
+	// QueryMany returns the date of first observation for multiple domains in the given namespace
+	QueryMany(namespace string, domains []string) ([]Entry, error)
+	// Namespaces returns every known namespace along with its number of entries
+	Namespaces() (map[string]int64, error)
+}
+
+// Constructor builds a NodDB backend from the "db" section of the YAML config.
+type Constructor func(cfg *koanf.Koanf) (NodDB, error)
+
+var registry = map[string]Constructor{}
+
+// Register makes a NodDB backend available under engine, so Open can
+// instantiate it by name. It is meant to be called from the init() function
+// of a backend's file, the same way database/sql drivers register themselves.
+func Register(engine string, constructor Constructor) {
+	registry[engine] = constructor
+}
+
+// Open instantiates the NodDB backend registered under engine, passing it the
+// "db" section of the YAML config. The returned NodDB is not yet connected;
+// callers must still call Open() on it.
+func Open(engine string, cfg *koanf.Koanf) (NodDB, error) {
+	constructor, ok := registry[engine]
+	if !ok {
+		return nil, fmt.Errorf("db: unsupported engine: %s", engine)
+	}
+	return constructor(cfg)
 }
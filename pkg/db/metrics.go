@@ -0,0 +1,97 @@
+package db
+
+import (
+	"time"
+
+	"github.com/mosajjal/nodzilla/pkg/metrics"
+)
+
+// instrumentedDB wraps a NodDB and records nodzilla_db_* metrics around every
+// read and write call, regardless of which backend is underneath. Open, Close
+// and Namespaces pass straight through to the wrapped NodDB uncounted.
+type instrumentedDB struct {
+	NodDB
+}
+
+// Instrument wraps db so its operations are counted via the
+// nodzilla_db_ops_total, nodzilla_query_hits_total/nodzilla_query_misses_total,
+// and nodzilla_db_batch_size metrics.
+func Instrument(db NodDB) NodDB {
+	return &instrumentedDB{NodDB: db}
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+func (d *instrumentedDB) Add(entry Entry) error {
+	err := d.NodDB.Add(entry)
+	metrics.DBOpsTotal.WithLabelValues("add", resultLabel(err)).Inc()
+	return err
+}
+
+func (d *instrumentedDB) AddMany(entries []Entry) error {
+	err := d.NodDB.AddMany(entries)
+	metrics.DBOpsTotal.WithLabelValues("add_many", resultLabel(err)).Inc()
+	metrics.DBBatchSize.Observe(float64(len(entries)))
+	return err
+}
+
+func (d *instrumentedDB) AddManyFast(entries []Entry) error {
+	err := d.NodDB.AddManyFast(entries)
+	metrics.DBOpsTotal.WithLabelValues("add_many_fast", resultLabel(err)).Inc()
+	metrics.DBBatchSize.Observe(float64(len(entries)))
+	return err
+}
+
+func (d *instrumentedDB) Flush() error {
+	err := d.NodDB.Flush()
+	metrics.DBOpsTotal.WithLabelValues("flush", resultLabel(err)).Inc()
+	return err
+}
+
+func (d *instrumentedDB) Delete(namespace, domain string) error {
+	err := d.NodDB.Delete(namespace, domain)
+	metrics.DBOpsTotal.WithLabelValues("delete", resultLabel(err)).Inc()
+	return err
+}
+
+func (d *instrumentedDB) DeleteMany(namespace string, domains []string) error {
+	err := d.NodDB.DeleteMany(namespace, domains)
+	metrics.DBOpsTotal.WithLabelValues("delete_many", resultLabel(err)).Inc()
+	metrics.DBBatchSize.Observe(float64(len(domains)))
+	return err
+}
+
+func (d *instrumentedDB) Query(namespace, domain string) (Entry, error) {
+	entry, err := d.NodDB.Query(namespace, domain)
+	metrics.DBOpsTotal.WithLabelValues("query", resultLabel(err)).Inc()
+	if err == nil {
+		observeHitOrMiss(entry)
+	}
+	return entry, err
+}
+
+func (d *instrumentedDB) QueryMany(namespace string, domains []string) ([]Entry, error) {
+	entries, err := d.NodDB.QueryMany(namespace, domains)
+	metrics.DBOpsTotal.WithLabelValues("query_many", resultLabel(err)).Inc()
+	if err == nil {
+		for _, entry := range entries {
+			observeHitOrMiss(entry)
+		}
+	}
+	return entries, err
+}
+
+// observeHitOrMiss distinguishes PebbleDB.Query's epoch-zero not-found
+// sentinel from an actual hit.
+func observeHitOrMiss(entry Entry) {
+	if entry.RegistrationDate.Equal(time.Unix(0, 0)) {
+		metrics.QueryMissesTotal.Inc()
+	} else {
+		metrics.QueryHitsTotal.Inc()
+	}
+}
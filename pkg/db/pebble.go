@@ -1,12 +1,24 @@
 package db
 
 import (
+	"bytes"
 	"encoding/json"
 	"time"
 
 	"github.com/cockroachdb/pebble"
+	"github.com/knadh/koanf"
 )
 
+func init() {
+	Register("pebble", func(cfg *koanf.Koanf) (NodDB, error) {
+		return NewPebbleDB(cfg.String("uri")), nil
+	})
+}
+
+// nsKeySep separates the namespace from the domain in a PebbleDB key. It is
+// a NUL byte so it can never collide with a valid domain name.
+const nsKeySep = '\x00'
+
 // PebbleDB is a wrapper around a Pebble database.
 type PebbleDB struct {
 	Path string
@@ -25,6 +37,20 @@ type Value struct {
 	TimeAdded     int64 `json:"now"`
 }
 
+// nsKey builds the namespace-prefixed key for a domain. Entries written
+// before namespaces existed have no prefix and are treated as belonging to
+// DefaultNamespace; see Query for the read-side migration path.
+func nsKey(namespace, domain string) []byte {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	key := make([]byte, 0, len(namespace)+1+len(domain))
+	key = append(key, namespace...)
+	key = append(key, nsKeySep)
+	key = append(key, domain...)
+	return key
+}
+
 // Open opens the database located at path.
 func (db *PebbleDB) Open() error {
 	var err error
@@ -37,7 +63,7 @@ func (db *PebbleDB) Close() error {
 	return db.DB.Close()
 }
 
-// Add adds an entry to the database.
+// Add adds an entry to the database, under the namespace in entry.Namespace.
 func (db *PebbleDB) Add(entry Entry) error {
 	// as a value, we will also store the "Now" timestamp
 	value := Value{
@@ -46,10 +72,10 @@ func (db *PebbleDB) Add(entry Entry) error {
 	}
 	// store the JSON representation of the entry
 	j, _ := json.Marshal(value)
-	return db.DB.Set([]byte(entry.Domain), j, pebble.Sync)
+	return db.DB.Set(nsKey(entry.Namespace, entry.Domain), j, pebble.Sync)
 }
 
-// AddMany adds many entries to the database.
+// AddMany adds many entries to the database, each under its own entry.Namespace.
 func (db *PebbleDB) AddMany(entries []Entry) error {
 	batch := db.DB.NewBatch()
 	for _, entry := range entries {
@@ -60,32 +86,79 @@ func (db *PebbleDB) AddMany(entries []Entry) error {
 		}
 		// store the JSON representation of the entry
 		j, _ := json.Marshal(value)
-		batch.Set([]byte(entry.Domain), j, pebble.Sync)
+		batch.Set(nsKey(entry.Namespace, entry.Domain), j, pebble.Sync)
 	}
 	return batch.Commit(pebble.Sync)
 }
 
-// Delete deletes an entry from the database.
-func (db *PebbleDB) Delete(domain string) error {
-	return db.DB.Delete([]byte(domain), pebble.Sync)
+// AddManyFast is like AddMany but commits with pebble.NoSync for throughput;
+// call Flush afterward for durability. Used by the ingest_stream endpoint.
+func (db *PebbleDB) AddManyFast(entries []Entry) error {
+	batch := db.DB.NewBatch()
+	for _, entry := range entries {
+		value := Value{
+			FirstObserved: entry.RegistrationDate.Unix(),
+			TimeAdded:     time.Now().Unix(),
+		}
+		j, _ := json.Marshal(value)
+		batch.Set(nsKey(entry.Namespace, entry.Domain), j, pebble.NoSync)
+	}
+	return batch.Commit(pebble.NoSync)
+}
+
+// Flush forces any writes made via AddManyFast's NoSync batches to stable storage.
+func (db *PebbleDB) Flush() error {
+	return db.DB.Flush()
 }
 
-// DeleteMany deletes many entries from the database.
-func (db *PebbleDB) DeleteMany(domains []string) error {
+// Delete deletes an entry from the given namespace. For DefaultNamespace it
+// also deletes the pre-namespace bare-domain key, mirroring Query's read-side
+// fallback so a deleted domain doesn't keep resolving via the legacy key.
+func (db *PebbleDB) Delete(namespace, domain string) error {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	if err := db.DB.Delete(nsKey(namespace, domain), pebble.Sync); err != nil {
+		return err
+	}
+	if namespace == DefaultNamespace {
+		return db.DB.Delete([]byte(domain), pebble.Sync)
+	}
+	return nil
+}
+
+// DeleteMany deletes many entries from the given namespace, with the same
+// legacy bare-domain-key cleanup as Delete.
+func (db *PebbleDB) DeleteMany(namespace string, domains []string) error {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
 	batch := db.DB.NewBatch()
 	for _, domain := range domains {
-		batch.Delete([]byte(domain), pebble.Sync)
+		batch.Delete(nsKey(namespace, domain), pebble.Sync)
+		if namespace == DefaultNamespace {
+			batch.Delete([]byte(domain), pebble.Sync)
+		}
 	}
 	return batch.Commit(pebble.Sync)
 }
 
-// Query queries the database for entries matching the given query.
-func (db *PebbleDB) Query(domain string) (Entry, error) {
-	v, _, err := db.DB.Get([]byte(domain))
+// Query queries the database for entries matching the given domain in the
+// given namespace. If nothing is found under the namespaced key and the
+// namespace is DefaultNamespace, it also checks the pre-namespace bare-domain
+// key, so data written before namespaces existed keeps resolving.
+func (db *PebbleDB) Query(namespace, domain string) (Entry, error) {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	v, _, err := db.DB.Get(nsKey(namespace, domain))
+	if err == pebble.ErrNotFound && namespace == DefaultNamespace {
+		v, _, err = db.DB.Get([]byte(domain))
+	}
 	// check specifically for "not found" errors
 	if err == pebble.ErrNotFound {
 		// return epoch 0 time
-		return Entry{Domain: domain, RegistrationDate: time.Unix(0, 0)}, nil
+		return Entry{Domain: domain, Namespace: namespace, RegistrationDate: time.Unix(0, 0)}, nil
 	}
 	if err != nil {
 		return Entry{}, err
@@ -97,14 +170,14 @@ func (db *PebbleDB) Query(domain string) (Entry, error) {
 		return Entry{}, err
 	}
 	// return the first observed time
-	return Entry{Domain: domain, RegistrationDate: time.Unix(value.FirstObserved, 0)}, nil
+	return Entry{Domain: domain, Namespace: namespace, RegistrationDate: time.Unix(value.FirstObserved, 0)}, nil
 }
 
-// QueryMany queries the database for entries matching the given query.
-func (db *PebbleDB) QueryMany(domains []string) ([]Entry, error) {
+// QueryMany queries the database for entries matching the given domains in the given namespace.
+func (db *PebbleDB) QueryMany(namespace string, domains []string) ([]Entry, error) {
 	entries := make([]Entry, 0, len(domains))
 	for _, domain := range domains {
-		entry, err := db.Query(domain)
+		entry, err := db.Query(namespace, domain)
 		if err != nil {
 			return nil, err
 		}
@@ -112,3 +185,25 @@ func (db *PebbleDB) QueryMany(domains []string) ([]Entry, error) {
 	}
 	return entries, nil
 }
+
+// Namespaces returns every namespace present in the database along with how
+// many domains it holds, by scanning all keys and grouping by the prefix
+// before nsKeySep. Bare pre-namespace keys are counted under DefaultNamespace.
+func (db *PebbleDB) Namespaces() (map[string]int64, error) {
+	iter, err := db.DB.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	counts := make(map[string]int64)
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		namespace := DefaultNamespace
+		if idx := bytes.IndexByte(key, nsKeySep); idx >= 0 {
+			namespace = string(key[:idx])
+		}
+		counts[namespace]++
+	}
+	return counts, iter.Error()
+}
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/knadh/koanf"
+)
+
+func init() {
+	Register("postgres", func(cfg *koanf.Koanf) (NodDB, error) {
+		return NewPostgresDB(cfg.String("uri")), nil
+	})
+}
+
+// postgresBatchSize is the batch size at or above which AddMany switches from plain
+// inserts to a COPY, which is considerably faster for large batches.
+const postgresBatchSize = 1000
+
+// PostgresDB is a NodDB backend that stores domains in a Postgres table, for
+// teams that already run Postgres and don't want a per-node Pebble store. It
+// is backed by a connection pool since the API may call it from many
+// goroutines at once, and a bare *pgx.Conn is not safe for concurrent use.
+type PostgresDB struct {
+	URI  string
+	Pool *pgxpool.Pool
+}
+
+// NewPostgresDB creates a new PostgresDB instance.
+// Note that the database is not connected until Open() is called.
+func NewPostgresDB(uri string) *PostgresDB {
+	return &PostgresDB{URI: uri}
+}
+
+// Open connects to Postgres and ensures the domains table exists.
+func (db *PostgresDB) Open() error {
+	pool, err := pgxpool.New(context.Background(), db.URI)
+	if err != nil {
+		return err
+	}
+	db.Pool = pool
+	_, err = pool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS domains (
+			namespace TEXT NOT NULL DEFAULT 'default',
+			domain TEXT NOT NULL,
+			first_observed TIMESTAMPTZ,
+			time_added TIMESTAMPTZ,
+			PRIMARY KEY (namespace, domain)
+		)`)
+	return err
+}
+
+// Close closes the connection pool to Postgres.
+func (db *PostgresDB) Close() error {
+	db.Pool.Close()
+	return nil
+}
+
+// namespaceOrDefault falls back to DefaultNamespace, matching PebbleDB's
+// treatment of an unspecified namespace.
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return DefaultNamespace
+	}
+	return namespace
+}
+
+// Add adds an entry to the database, under the namespace in entry.Namespace.
+func (db *PostgresDB) Add(entry Entry) error {
+	_, err := db.Pool.Exec(context.Background(),
+		`INSERT INTO domains (namespace, domain, first_observed, time_added) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (namespace, domain) DO NOTHING`,
+		namespaceOrDefault(entry.Namespace), entry.Domain, entry.RegistrationDate, time.Now())
+	return err
+}
+
+// AddMany adds many entries to the database as a single transaction. Large
+// batches are streamed in via COPY into a temp table, then merged into
+// domains with ON CONFLICT DO NOTHING, since COPY itself has no way to skip
+// rows that collide with existing ones.
+func (db *PostgresDB) AddMany(entries []Entry) error {
+	ctx := context.Background()
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if len(entries) >= postgresBatchSize {
+		now := time.Now()
+		if _, err := tx.Exec(ctx, `CREATE TEMP TABLE domains_staging (
+			namespace TEXT, domain TEXT, first_observed TIMESTAMPTZ, time_added TIMESTAMPTZ
+		) ON COMMIT DROP`); err != nil {
+			return err
+		}
+		rows := make([][]any, len(entries))
+		for i, entry := range entries {
+			rows[i] = []any{namespaceOrDefault(entry.Namespace), entry.Domain, entry.RegistrationDate, now}
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"domains_staging"}, []string{"namespace", "domain", "first_observed", "time_added"}, pgx.CopyFromRows(rows)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO domains (namespace, domain, first_observed, time_added)
+			SELECT namespace, domain, first_observed, time_added FROM domains_staging
+			ON CONFLICT (namespace, domain) DO NOTHING`); err != nil {
+			return err
+		}
+	} else {
+		batch := &pgx.Batch{}
+		now := time.Now()
+		for _, entry := range entries {
+			batch.Queue(`INSERT INTO domains (namespace, domain, first_observed, time_added) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (namespace, domain) DO NOTHING`, namespaceOrDefault(entry.Namespace), entry.Domain, entry.RegistrationDate, now)
+		}
+		if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// AddManyFast is the same as AddMany: Postgres commits are already durable,
+// so there's no separate fast/sync path to take.
+func (db *PostgresDB) AddManyFast(entries []Entry) error {
+	return db.AddMany(entries)
+}
+
+// Flush is a no-op, since PostgresDB has no relaxed-durability writes to flush.
+func (db *PostgresDB) Flush() error {
+	return nil
+}
+
+// Delete removes an entry from the given namespace.
+func (db *PostgresDB) Delete(namespace, domain string) error {
+	_, err := db.Pool.Exec(context.Background(), `DELETE FROM domains WHERE namespace = $1 AND domain = $2`, namespaceOrDefault(namespace), domain)
+	return err
+}
+
+// DeleteMany removes many entries from the given namespace as a single transaction.
+func (db *PostgresDB) DeleteMany(namespace string, domains []string) error {
+	ctx := context.Background()
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM domains WHERE namespace = $1 AND domain = ANY($2)`, namespaceOrDefault(namespace), domains); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Query queries the database for an entry matching the given domain in the given namespace.
+func (db *PostgresDB) Query(namespace, domain string) (Entry, error) {
+	namespace = namespaceOrDefault(namespace)
+	var firstObserved time.Time
+	err := db.Pool.QueryRow(context.Background(),
+		`SELECT first_observed FROM domains WHERE namespace = $1 AND domain = $2`, namespace, domain).Scan(&firstObserved)
+	if err == pgx.ErrNoRows {
+		// return epoch 0 time, matching PebbleDB's not-found behaviour
+		return Entry{Domain: domain, Namespace: namespace, RegistrationDate: time.Unix(0, 0)}, nil
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Domain: domain, Namespace: namespace, RegistrationDate: firstObserved}, nil
+}
+
+// QueryMany queries the database for entries matching the given domains in the given namespace.
+func (db *PostgresDB) QueryMany(namespace string, domains []string) ([]Entry, error) {
+	namespace = namespaceOrDefault(namespace)
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx, `SELECT domain, first_observed FROM domains WHERE namespace = $1 AND domain = ANY($2)`, namespace, domains)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]time.Time, len(domains))
+	for rows.Next() {
+		var domain string
+		var firstObserved time.Time
+		if err := rows.Scan(&domain, &firstObserved); err != nil {
+			return nil, err
+		}
+		found[domain] = firstObserved
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(domains))
+	for _, domain := range domains {
+		if firstObserved, ok := found[domain]; ok {
+			entries = append(entries, Entry{Domain: domain, Namespace: namespace, RegistrationDate: firstObserved})
+		} else {
+			entries = append(entries, Entry{Domain: domain, Namespace: namespace, RegistrationDate: time.Unix(0, 0)})
+		}
+	}
+	return entries, nil
+}
+
+// Namespaces returns every namespace present in the database along with how
+// many domains it holds.
+func (db *PostgresDB) Namespaces() (map[string]int64, error) {
+	rows, err := db.Pool.Query(context.Background(), `SELECT namespace, count(*) FROM domains GROUP BY namespace`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var namespace string
+		var count int64
+		if err := rows.Scan(&namespace, &count); err != nil {
+			return nil, err
+		}
+		counts[namespace] = count
+	}
+	return counts, rows.Err()
+}
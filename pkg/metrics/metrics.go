@@ -0,0 +1,49 @@
+// Package metrics holds the Prometheus collectors shared by the API and DB
+// layers, so both can be instrumented without depending on each other.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// HTTPRequestsTotal counts completed HTTP requests by path, method and status.
+	HTTPRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nodzilla_http_requests_total",
+		Help: "Total number of HTTP requests processed, by path, method and status.",
+	}, []string{"path", "method", "status"})
+
+	// HTTPRequestDuration observes how long HTTP requests take to serve.
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "nodzilla_http_request_duration_seconds",
+		Help: "HTTP request duration in seconds, by path and method.",
+	}, []string{"path", "method"})
+
+	// DBOpsTotal counts DB operations by op (add, add_many, add_many_fast,
+	// flush, delete, delete_many, query, query_many) and result (ok, error).
+	DBOpsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nodzilla_db_ops_total",
+		Help: "Total number of DB operations, by op and result.",
+	}, []string{"op", "result"})
+
+	// QueryHitsTotal counts Query/QueryMany lookups that found an entry.
+	QueryHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nodzilla_query_hits_total",
+		Help: "Total number of domain queries that found a registration date.",
+	})
+
+	// QueryMissesTotal counts Query/QueryMany lookups that returned the
+	// epoch-zero not-found sentinel.
+	QueryMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nodzilla_query_misses_total",
+		Help: "Total number of domain queries that found no registration date.",
+	})
+
+	// DBBatchSize observes the size of AddMany/AddManyFast/DeleteMany batches.
+	DBBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "nodzilla_db_batch_size",
+		Help:    "Size of AddMany/AddManyFast/DeleteMany batches.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	})
+)
@@ -0,0 +1,334 @@
+// Package tls provides automatic certificate issuance and renewal via ACME
+// (Let's Encrypt and compatible CAs), so nodzilla can serve HTTPS without an
+// external cert-manager sidecar.
+package tls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/providers/dns/cloudflare"
+	"github.com/go-acme/lego/v4/providers/dns/gandi"
+	"github.com/go-acme/lego/v4/providers/dns/route53"
+	"github.com/go-acme/lego/v4/providers/dns/sakuracloud"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/rs/zerolog"
+)
+
+// renewBefore is how long before expiry a certificate is renewed.
+const renewBefore = 30 * 24 * time.Hour
+
+// Config is the YAML-driven configuration for the auto_tls subsystem.
+type Config struct {
+	// Domains is the list of hostnames to obtain a certificate for. The
+	// first entry is used as the certificate's CommonName.
+	Domains []string
+	// Email is the account contact address registered with the CA.
+	Email string
+	// CacheDir is where the ACME account key and issued certificates are stored.
+	CacheDir string
+	// CADirURL is the ACME directory URL. Defaults to Let's Encrypt production.
+	CADirURL string
+	// Challenge selects the ACME challenge type: "http-01" or "dns-01".
+	Challenge string
+	// HTTPChallengeAddr is the listen address for the HTTP-01 challenge
+	// responder, e.g. ":80". Only used when Challenge is "http-01".
+	HTTPChallengeAddr string
+	// DNSProvider selects the DNS-01 provider: cloudflare, route53, gandi, sakuracloud.
+	DNSProvider string
+	// DNSCredentials is a map of provider-specific credential env var names to
+	// values (e.g. CLOUDFLARE_DNS_API_TOKEN), as documented by each lego provider.
+	DNSCredentials map[string]string
+	// Logger is the logger to use.
+	Logger *zerolog.Logger
+}
+
+// acmeUser implements registration.User, the account identity lego uses to
+// talk to the ACME server.
+type acmeUser struct {
+	Email        string
+	Registration *registration.Resource
+	key          crypto.PrivateKey
+}
+
+func (u *acmeUser) GetEmail() string                        { return u.Email }
+func (u *acmeUser) GetRegistration() *registration.Resource { return u.Registration }
+func (u *acmeUser) GetPrivateKey() crypto.PrivateKey        { return u.key }
+
+// Manager obtains and renews an ACME certificate in the background, and
+// serves the current certificate through a crypto/tls.Config.GetCertificate
+// callback.
+type Manager struct {
+	C Config
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	expiry time.Time
+
+	client *lego.Client
+	user   *acmeUser
+}
+
+// NewManager creates a Manager and loads or bootstraps the ACME account and
+// certificate. It does not start the renewal loop; call StartRenewalLoop for that.
+func NewManager(cfg Config) (*Manager, error) {
+	if len(cfg.Domains) == 0 {
+		return nil, errors.New("tls: at least one domain is required for auto_tls")
+	}
+	if cfg.CacheDir == "" {
+		return nil, errors.New("tls: cache_dir is required for auto_tls")
+	}
+	if err := os.MkdirAll(cfg.CacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("tls: failed to create cache dir: %w", err)
+	}
+
+	m := &Manager{C: cfg}
+
+	user, err := m.loadOrCreateUser()
+	if err != nil {
+		return nil, fmt.Errorf("tls: failed to load ACME account: %w", err)
+	}
+	m.user = user
+
+	legoConfig := lego.NewConfig(user)
+	if cfg.CADirURL != "" {
+		legoConfig.CADirURL = cfg.CADirURL
+	}
+	client, err := lego.NewClient(legoConfig)
+	if err != nil {
+		return nil, fmt.Errorf("tls: failed to create ACME client: %w", err)
+	}
+	m.client = client
+
+	if err := m.setChallengeProvider(); err != nil {
+		return nil, err
+	}
+
+	if user.Registration == nil {
+		reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		if err != nil {
+			return nil, fmt.Errorf("tls: failed to register ACME account: %w", err)
+		}
+		user.Registration = reg
+		if err := m.saveUser(user); err != nil {
+			return nil, fmt.Errorf("tls: failed to persist ACME account: %w", err)
+		}
+	}
+
+	if err := m.loadCertFromDisk(); err != nil || m.needsRenewal() {
+		if err := m.obtain(); err != nil {
+			return nil, fmt.Errorf("tls: failed to obtain certificate: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Manager) setChallengeProvider() error {
+	switch m.C.Challenge {
+	case "", "http-01":
+		srv := http01.NewProviderServer("", portFromAddr(m.C.HTTPChallengeAddr))
+		return m.client.Challenge.SetHTTP01Provider(srv)
+	case "dns-01":
+		provider, err := newDNSProvider(m.C.DNSProvider, m.C.DNSCredentials)
+		if err != nil {
+			return err
+		}
+		return m.client.Challenge.SetDNS01Provider(provider)
+	default:
+		return fmt.Errorf("tls: unsupported challenge type: %s", m.C.Challenge)
+	}
+}
+
+func portFromAddr(addr string) string {
+	if addr == "" {
+		return "80"
+	}
+	parts := strings.Split(addr, ":")
+	return parts[len(parts)-1]
+}
+
+// newDNSProvider sets the credentials as environment variables and
+// instantiates the matching lego DNS-01 provider, which is how each of
+// these providers reads its configuration.
+func newDNSProvider(name string, creds map[string]string) (challengeProvider, error) {
+	for k, v := range creds {
+		if err := os.Setenv(k, v); err != nil {
+			return nil, fmt.Errorf("tls: failed to set env var %s: %w", k, err)
+		}
+	}
+	switch name {
+	case "cloudflare":
+		return cloudflare.NewDNSProvider()
+	case "route53":
+		return route53.NewDNSProvider()
+	case "gandi":
+		return gandi.NewDNSProvider()
+	case "sakuracloud":
+		return sakuracloud.NewDNSProvider()
+	default:
+		return nil, fmt.Errorf("tls: unsupported dns provider: %s", name)
+	}
+}
+
+// challengeProvider is satisfied by every lego DNS-01 provider's NewDNSProvider.
+type challengeProvider interface {
+	Present(domain, token, keyAuth string) error
+	CleanUp(domain, token, keyAuth string) error
+}
+
+func (m *Manager) obtain() error {
+	request := certificate.ObtainRequest{
+		Domains: m.C.Domains,
+		Bundle:  true,
+	}
+	resource, err := m.client.Certificate.Obtain(request)
+	if err != nil {
+		return err
+	}
+	if err := m.saveCertToDisk(resource); err != nil {
+		return err
+	}
+	return m.loadCertFromDisk()
+}
+
+// GetCertificate is plugged into crypto/tls.Config.GetCertificate so the
+// server always presents the most recently issued certificate.
+func (m *Manager) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, errors.New("tls: no certificate available yet")
+	}
+	return m.cert, nil
+}
+
+func (m *Manager) needsRenewal() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return true
+	}
+	return time.Now().After(m.expiry.Add(-renewBefore))
+}
+
+// StartRenewalLoop starts a background goroutine that checks the current
+// certificate's expiry once a day and renews it 30 days before it expires.
+// It returns immediately; the goroutine exits once stop is closed.
+func (m *Manager) StartRenewalLoop(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !m.needsRenewal() {
+					continue
+				}
+				if err := m.obtain(); err != nil && m.C.Logger != nil {
+					m.C.Logger.Error().Msgf("tls: failed to renew certificate: %s", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Manager) accountKeyPath() string {
+	return filepath.Join(m.C.CacheDir, "account.key")
+}
+
+func (m *Manager) accountPath() string {
+	return filepath.Join(m.C.CacheDir, "account.json")
+}
+
+func (m *Manager) certPath() string {
+	return filepath.Join(m.C.CacheDir, m.C.Domains[0]+".crt")
+}
+
+func (m *Manager) keyPath() string {
+	return filepath.Join(m.C.CacheDir, m.C.Domains[0]+".key")
+}
+
+func (m *Manager) loadOrCreateUser() (*acmeUser, error) {
+	keyBytes, keyErr := os.ReadFile(m.accountKeyPath())
+	accountBytes, accountErr := os.ReadFile(m.accountPath())
+
+	if keyErr == nil && accountErr == nil {
+		block, _ := pem.Decode(keyBytes)
+		if block == nil {
+			return nil, errors.New("tls: invalid account key PEM")
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		var user acmeUser
+		if err := json.Unmarshal(accountBytes, &user); err != nil {
+			return nil, err
+		}
+		user.key = key
+		return &user, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(m.accountKeyPath(), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, err
+	}
+	return &acmeUser{Email: m.C.Email, key: key}, nil
+}
+
+func (m *Manager) saveUser(user *acmeUser) error {
+	b, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.accountPath(), b, 0600)
+}
+
+func (m *Manager) saveCertToDisk(resource *certificate.Resource) error {
+	if err := os.WriteFile(m.certPath(), resource.Certificate, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(m.keyPath(), resource.PrivateKey, 0600)
+}
+
+func (m *Manager) loadCertFromDisk() error {
+	cert, err := tls.LoadX509KeyPair(m.certPath(), m.keyPath())
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cert = &cert
+	m.expiry = leaf.NotAfter
+	m.mu.Unlock()
+	return nil
+}